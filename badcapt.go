@@ -2,12 +2,14 @@ package badcapt
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
-	"io"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/fastcache"
@@ -34,7 +36,8 @@ var defaultMarkers = []Marker{
 	MasscanIdentifier,
 }
 
-var defaultSeriesMarkers = []SeriesMarker{}
+// defaultCacheSize is used when SetCacheSize was not called.
+const defaultCacheSize = 32 * 1024 * 1024
 
 // Badcapt defines badcapt configuration
 type Badcapt struct {
@@ -43,8 +46,26 @@ type Badcapt struct {
 	docType       string
 	markers       []Marker
 	seriesMarkers []SeriesMarker
+	exporters     []Exporter
 	cache         *fastcache.Cache
 	cacheSize     int
+	scan          *scanCache
+	flows         *flowTable
+	flowWindow    time.Duration
+	exportTimeout time.Duration
+	workers       int
+	dropped       uint64
+	snaplen       int32
+	promisc       bool
+	bpfFilter     string
+	enrichers     []Enricher
+}
+
+// Enricher adds extra context to a Record, such as GeoIP or ASN data, before
+// it is exported.
+type Enricher interface {
+	Enrich(record *Record) error
+	Close() error
 }
 
 // TaggedPacket represents a packet that went through markers.
@@ -60,10 +81,17 @@ type Record struct {
 	SrcPort        uint16    `json:"src_port"`
 	DstIP          net.IP    `json:"dst_ip,omitempty"`
 	DstPort        uint16    `json:"dst_port"`
+	TTL            uint8     `json:"ttl"`
+	TCPFlags       string    `json:"tcp_flags,omitempty"`
+	Window         uint16    `json:"window,omitempty"`
+	PayloadSHA256  string    `json:"payload_sha256,omitempty"`
 	Timestamp      time.Time `json:"date"`
 	Tags           []string  `json:"tags"`
 	Payload        []byte    `json:"payload,omitempty"`
 	PayloadString  string    `json:"payload_str,omitempty"`
+	SrcCountry     string    `json:"src_country,omitempty"`
+	SrcASN         uint      `json:"src_asn,omitempty"`
+	SrcOrg         string    `json:"src_org,omitempty"`
 }
 
 func unpackIPv4(p gopacket.Packet) *layers.IPv4 {
@@ -76,6 +104,16 @@ func unpackIPv4(p gopacket.Packet) *layers.IPv4 {
 	return ip4
 }
 
+func unpackIPv6(p gopacket.Packet) *layers.IPv6 {
+	ip6Layer := p.Layer(layers.LayerTypeIPv6)
+	if ip6Layer == nil {
+		return nil
+	}
+	ip6 := ip6Layer.(*layers.IPv6)
+
+	return ip6
+}
+
 func unpackTCP(p gopacket.Packet) *layers.TCP {
 	tcpLayer := p.Layer(layers.LayerTypeTCP)
 	if tcpLayer == nil {
@@ -86,11 +124,46 @@ func unpackTCP(p gopacket.Packet) *layers.TCP {
 	return tcp
 }
 
+// tcpFlagsString renders the set TCP control bits as a comma separated
+// list, e.g. "SYN,ACK".
+func tcpFlagsString(tcp *layers.TCP) string {
+	var flags []string
+
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{tcp.FIN, "FIN"},
+		{tcp.SYN, "SYN"},
+		{tcp.RST, "RST"},
+		{tcp.PSH, "PSH"},
+		{tcp.ACK, "ACK"},
+		{tcp.URG, "URG"},
+		{tcp.ECE, "ECE"},
+		{tcp.CWR, "CWR"},
+		{tcp.NS, "NS"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+
+	return strings.Join(flags, ",")
+}
+
 // NewRecord constructs a record to write to the database
 func NewRecord(tp *TaggedPacket) (*Record, error) {
-	ip4 := unpackIPv4(tp.Packet)
-	if ip4 == nil {
-		return nil, errors.New("not ip4 type packet")
+	var (
+		srcIP, dstIP net.IP
+		ttl          uint8
+	)
+
+	if ip4 := unpackIPv4(tp.Packet); ip4 != nil {
+		srcIP, dstIP, ttl = ip4.SrcIP, ip4.DstIP, ip4.TTL
+	} else if ip6 := unpackIPv6(tp.Packet); ip6 != nil {
+		srcIP, dstIP, ttl = ip6.SrcIP, ip6.DstIP, ip6.HopLimit
+	} else {
+		return nil, errors.New("neither ip4 nor ip6 type packet")
 	}
 
 	udpLayer := tp.Packet.Layer(layers.LayerTypeUDP)
@@ -99,6 +172,8 @@ func NewRecord(tp *TaggedPacket) (*Record, error) {
 		srcPort   uint16
 		dstPort   uint16
 		transport string
+		tcpFlags  string
+		window    uint16
 	)
 
 	if tcpLayer != nil {
@@ -106,6 +181,8 @@ func NewRecord(tp *TaggedPacket) (*Record, error) {
 		srcPort = uint16(tcp.SrcPort)
 		dstPort = uint16(tcp.DstPort)
 		transport = "tcp"
+		tcpFlags = tcpFlagsString(tcp)
+		window = tcp.Window
 	} else if udpLayer != nil {
 		udp := udpLayer.(*layers.UDP)
 		srcPort = uint16(udp.SrcPort)
@@ -121,11 +198,21 @@ func NewRecord(tp *TaggedPacket) (*Record, error) {
 		payload = appLayer.Payload()
 	}
 
+	var payloadHash string
+	if len(payload) > 0 {
+		sum := sha256.Sum256(payload)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
 	return &Record{
-		SrcIP:          ip4.SrcIP,
-		DstIP:          ip4.DstIP,
+		SrcIP:          srcIP,
+		DstIP:          dstIP,
+		TTL:            ttl,
 		SrcPort:        srcPort,
 		DstPort:        dstPort,
+		TCPFlags:       tcpFlags,
+		Window:         window,
+		PayloadSHA256:  payloadHash,
 		Timestamp:      tp.Packet.Metadata().CaptureInfo.Timestamp,
 		Payload:        payload,
 		PayloadString:  string(payload),
@@ -134,37 +221,79 @@ func NewRecord(tp *TaggedPacket) (*Record, error) {
 	}, nil
 }
 
+// export fans a record out to every configured Exporter concurrently and
+// reports the first error encountered, if any.
 func (b *Badcapt) export(ctx context.Context, tp *TaggedPacket) error {
 	record, err := NewRecord(tp)
 	if err != nil {
 		return err
 	}
 
-	if b.client == nil {
-		return exportScreen(record)
+	for _, e := range b.enrichers {
+		if err := e.Enrich(record); err != nil {
+			log.Println(err)
+		}
+	}
+
+	errs := make([]error, len(b.exporters))
+
+	var wg sync.WaitGroup
+	for i, exp := range b.exporters {
+		wg.Add(1)
+		go func(i int, exp Exporter) {
+			defer wg.Done()
+
+			ctx := ctx
+			if b.exportTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, b.exportTimeout)
+				defer cancel()
+			}
+
+			errs[i] = exp.Export(ctx, record)
+		}(i, exp)
 	}
+	wg.Wait()
 
-	return b.exportElastic(ctx, record)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (b *Badcapt) exportElastic(ctx context.Context, record *Record) error {
-	_, err := b.client.Index().
-		Index(b.indexName).
-		Type(b.docType).
-		BodyJson(record).
-		Do(ctx)
+// setupFlowTracking builds the fastcache-backed scan detectors and the flow
+// table that feeds them and the SeriesMarkers. It must run after options
+// have been applied, since SetCacheSize and FlowWindow influence it.
+func (b *Badcapt) setupFlowTracking() {
+	size := b.cacheSize
+	if size <= 0 {
+		size = defaultCacheSize
+	}
 
-	return err
+	b.cache = fastcache.New(size)
+	b.scan = &scanCache{cache: b.cache}
+	b.flows = newFlowTable(b.flowWindow)
+	b.seriesMarkers = append([]SeriesMarker{b.synOnlyFloodMarker}, b.seriesMarkers...)
 }
 
-func exportScreen(record *Record) error {
-	data, err := json.Marshal(record)
-	if err != nil {
-		return err
+// synOnlyFloodMarker is the built-in SeriesMarker that flags flows whose
+// handshake never completed, feeding the synOnlyFlood scan counter.
+func (b *Badcapt) synOnlyFloodMarker(packets ...gopacket.Packet) []string {
+	if len(packets) == 0 || !synOnly(packets) {
+		return nil
 	}
-	fmt.Println(string(data))
 
-	return nil
+	last := packets[len(packets)-1]
+	ip4 := unpackIPv4(last)
+	tcp := unpackTCP(last)
+	if ip4 == nil || tcp == nil {
+		return nil
+	}
+
+	return b.synOnlyFlood(ip4, uint16(tcp.DstPort), last.Metadata().CaptureInfo.Timestamp)
 }
 
 // New bootstraps badcapt configuration.
@@ -174,6 +303,8 @@ func New(opts ...func(*Badcapt) error) (*Badcapt, error) {
 		indexName: indexName,
 		docType:   docType,
 		markers:   defaultMarkers,
+		snaplen:   defaultSnapLen,
+		promisc:   true,
 	}
 
 	for _, f := range opts {
@@ -183,22 +314,32 @@ func New(opts ...func(*Badcapt) error) (*Badcapt, error) {
 		}
 	}
 
-	if conf.client == nil {
-		return conf, nil
-	}
-
-	exists, err := conf.client.IndexExists(indexName).Do(context.Background())
-	if err != nil {
-		return nil, err
-	}
-
-	if !exists {
-		_, err := conf.client.CreateIndex(indexName).Do(context.Background())
+	if conf.client != nil {
+		exists, err := conf.client.IndexExists(conf.indexName).Do(context.Background())
 		if err != nil {
 			return nil, err
 		}
+
+		if !exists {
+			_, err := conf.client.CreateIndex(conf.indexName).Do(context.Background())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		conf.exporters = append(conf.exporters, &elasticExporter{
+			client:    conf.client,
+			indexName: conf.indexName,
+			docType:   conf.docType,
+		})
+	}
+
+	if len(conf.exporters) == 0 {
+		conf.exporters = append(conf.exporters, screenExporter{})
 	}
 
+	conf.setupFlowTracking()
+
 	return conf, nil
 }
 
@@ -210,6 +351,40 @@ func AddPacketMarker(m Marker) func(*Badcapt) error {
 	}
 }
 
+// AddExporter registers an additional sink that every Record is exported to,
+// alongside Elasticsearch if a client is set. The screen sink is only used
+// when no exporter at all is configured, so it is not added next to e.
+func AddExporter(e Exporter) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.exporters = append(b.exporters, e)
+		return nil
+	}
+}
+
+// AddEnricher registers an additional routine that adds context to every
+// Record before it is exported.
+func AddEnricher(e Enricher) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.enrichers = append(b.enrichers, e)
+		return nil
+	}
+}
+
+// SetGeoIPDB wires up the built-in GeoIPEnricher against a MaxMind GeoLite2
+// database at path, filling SrcCountry, SrcASN and SrcOrg on every Record.
+func SetGeoIPDB(path string) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		e, err := NewGeoIPEnricher(path)
+		if err != nil {
+			return err
+		}
+
+		b.enrichers = append(b.enrichers, e)
+
+		return nil
+	}
+}
+
 // SetElastic sets elasticsearch client to export events to.
 func SetElastic(client *elastic.Client) func(*Badcapt) error {
 	return func(b *Badcapt) error {
@@ -242,6 +417,26 @@ func SetCacheSize(size int) func(*Badcapt) error {
 	}
 }
 
+// SetExportTimeout bounds every Exporter call with its own deadline, scoped
+// to that single Export call so concurrent exports never reset one
+// another's deadline; zero (the default) disables the deadline.
+func SetExportTimeout(d time.Duration) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.exportTimeout = d
+		return nil
+	}
+}
+
+// SetWorkerPool caps how many packets are handled concurrently to n, backed
+// by a bounded channel. Once the pool is saturated, incoming packets are
+// dropped and counted instead of spawning unbounded goroutines.
+func SetWorkerPool(n int) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.workers = n
+		return nil
+	}
+}
+
 // NewConfig bootstraps badcapt configuration.
 // Deprecated. Use New instead.
 func NewConfig(elasticLoc string, markers ...Marker) (*Badcapt, error) {
@@ -257,6 +452,8 @@ func NewConfig(elasticLoc string, markers ...Marker) (*Badcapt, error) {
 		client:    client,
 		indexName: indexName,
 		docType:   docType,
+		snaplen:   defaultSnapLen,
+		promisc:   true,
 	}
 
 	exists, err := client.IndexExists(indexName).Do(context.Background())
@@ -271,41 +468,127 @@ func NewConfig(elasticLoc string, markers ...Marker) (*Badcapt, error) {
 		}
 	}
 
+	conf.exporters = []Exporter{&elasticExporter{
+		client:    client,
+		indexName: indexName,
+		docType:   docType,
+	}}
+
 	if len(markers) == 0 {
 		conf.markers = defaultMarkers
 	}
 
+	conf.setupFlowTracking()
+
 	return conf, nil
 }
 
-// Listen starts packet sniffing and processing
+// defaultWorkerPool is used when SetWorkerPool was not called.
+const defaultWorkerPool = 64
+
+// Stats reports runtime counters about a running Badcapt.
+type Stats struct {
+	// Dropped counts packets discarded because the worker pool was
+	// saturated.
+	Dropped uint64
+}
+
+// Stats returns the current runtime counters.
+func (b *Badcapt) Stats() Stats {
+	return Stats{Dropped: atomic.LoadUint64(&b.dropped)}
+}
+
+// Close shuts down every configured Exporter and Enricher, flushing any
+// buffered writes or connections, and reports the first error encountered,
+// if any. It should be called once ListenContext has returned.
+func (b *Badcapt) Close() error {
+	var firstErr error
+
+	for _, exp := range b.exporters {
+		if err := exp.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, e := range b.enrichers {
+		if err := e.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Listen starts packet sniffing and processing. It runs until the capture
+// handle is exhausted or errors out; use ListenContext to stop it early.
 func (b *Badcapt) Listen(iface string) error {
-	handle, err := pcap.OpenLive(iface, 1600, true, pcap.BlockForever)
+	return b.ListenContext(context.Background(), iface)
+}
+
+// ListenContext starts packet sniffing and processing, honoring ctx: once
+// ctx is done, it stops reading new packets and waits for in-flight
+// handlers to drain before returning ctx.Err(). Packet handling is capped
+// at SetWorkerPool concurrent goroutines; once that pool is saturated,
+// packets are dropped and counted rather than spawning unbounded
+// goroutines.
+func (b *Badcapt) ListenContext(ctx context.Context, iface string) error {
+	handle, err := pcap.OpenLive(iface, b.snapLen(), b.promisc, pcap.BlockForever)
 	if err != nil {
 		return err
 	}
 	defer handle.Close()
+
+	if err := b.applyBPFFilter(handle); err != nil {
+		return err
+	}
 	log.Printf("Started capturing on iface %s", iface)
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	return b.listen(ctx, gopacket.NewPacketSource(handle, handle.LinkType()))
+}
+
+// listen drains a packet source through the worker pool until ctx is done
+// or the source is exhausted, waiting for in-flight handlers before
+// returning.
+func (b *Badcapt) listen(ctx context.Context, packetSource *gopacket.PacketSource) error {
+	workers := b.workers
+	if workers <= 0 {
+		workers = defaultWorkerPool
+	}
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	packets := packetSource.Packets()
+
 	for {
-		p, err := packetSource.NextPacket()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Println(err)
-			continue
-		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+
+		case p, ok := <-packets:
+			if !ok {
+				wg.Wait()
+				return nil
+			}
 
-		go func() {
-			hErr := b.handle(p)
-			if hErr != nil {
-				log.Println(hErr)
+			select {
+			case sem <- struct{}{}:
+			default:
+				atomic.AddUint64(&b.dropped, 1)
+				continue
 			}
-		}()
-	}
 
-	return nil
+			wg.Add(1)
+			go func(p gopacket.Packet) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				if err := b.handle(p); err != nil {
+					log.Println(err)
+				}
+			}(p)
+		}
+	}
 }
 
 func (b *Badcapt) handle(p gopacket.Packet) error {
@@ -315,9 +598,7 @@ func (b *Badcapt) handle(p gopacket.Packet) error {
 		tags = append(tags, fn(p)...)
 	}
 
-	for _, sfn := range b.seriesMarkers {
-		tags = append(tags, sfn(p)...)
-	}
+	tags = append(tags, b.trackFlow(p)...)
 
 	if len(tags) == 0 {
 		return nil
@@ -329,3 +610,36 @@ func (b *Badcapt) handle(p gopacket.Packet) error {
 
 	return nil
 }
+
+// trackFlow feeds p into the scan detectors and the flow table, running the
+// SeriesMarkers over any flow that just closed or went idle. It returns the
+// scan tags raised by p itself; tags raised by a closed flow are exported on
+// that flow's own last packet rather than returned here.
+func (b *Badcapt) trackFlow(p gopacket.Packet) []string {
+	ip4 := unpackIPv4(p)
+	tcp := unpackTCP(p)
+	if ip4 == nil || tcp == nil {
+		return nil
+	}
+
+	seen := p.Metadata().CaptureInfo.Timestamp
+	tags := b.scanTags(ip4, uint16(tcp.DstPort), seen)
+
+	for _, flowPackets := range b.flows.observe(p, ip4, tcp) {
+		var flowTags []string
+		for _, sfn := range b.seriesMarkers {
+			flowTags = append(flowTags, sfn(flowPackets...)...)
+		}
+
+		if len(flowTags) == 0 {
+			continue
+		}
+
+		last := flowPackets[len(flowPackets)-1]
+		if err := b.export(context.Background(), &TaggedPacket{last, flowTags}); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return tags
+}
@@ -0,0 +1,108 @@
+package badcapt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func ipv4TCPPacket(t *testing.T, payload []byte) gopacket.Packet {
+	t.Helper()
+
+	ip4 := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IPv4(192, 168, 1, 1),
+		DstIP:    net.IPv4(192, 168, 1, 2),
+	}
+	tcp := &layers.TCP{
+		SrcPort: 1234,
+		DstPort: 80,
+		SYN:     true,
+		ACK:     true,
+		Window:  512,
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip4); err != nil {
+		t.Fatalf("SetNetworkLayerForChecksum: %v", err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip4, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("serialize packet: %v", err)
+	}
+
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+}
+
+func ipv6UDPPacket(t *testing.T) gopacket.Packet {
+	t.Helper()
+
+	ip6 := &layers.IPv6{
+		Version:    6,
+		HopLimit:   34,
+		NextHeader: layers.IPProtocolUDP,
+		SrcIP:      net.ParseIP("2001:db8::1"),
+		DstIP:      net.ParseIP("2001:db8::2"),
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 9999}
+	if err := udp.SetNetworkLayerForChecksum(ip6); err != nil {
+		t.Fatalf("SetNetworkLayerForChecksum: %v", err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip6, udp); err != nil {
+		t.Fatalf("serialize packet: %v", err)
+	}
+
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv6, gopacket.Default)
+}
+
+func TestNewRecordIPv6UsesHopLimitAsTTL(t *testing.T) {
+	record, err := NewRecord(&TaggedPacket{Packet: ipv6UDPPacket(t)})
+	if err != nil {
+		t.Fatalf("NewRecord: %v", err)
+	}
+
+	if record.TransportProto != "udp" {
+		t.Fatalf("got transport %q, want udp", record.TransportProto)
+	}
+	if record.TTL != 34 {
+		t.Fatalf("got TTL %d, want HopLimit 34", record.TTL)
+	}
+	if !record.SrcIP.Equal(net.ParseIP("2001:db8::1")) || !record.DstIP.Equal(net.ParseIP("2001:db8::2")) {
+		t.Fatalf("got src/dst %s/%s, want 2001:db8::1/2001:db8::2", record.SrcIP, record.DstIP)
+	}
+}
+
+func TestNewRecordEmptyPayloadLeavesHashEmpty(t *testing.T) {
+	record, err := NewRecord(&TaggedPacket{Packet: ipv6UDPPacket(t)})
+	if err != nil {
+		t.Fatalf("NewRecord: %v", err)
+	}
+
+	if record.PayloadSHA256 != "" {
+		t.Fatalf("got PayloadSHA256 %q for an empty payload, want empty", record.PayloadSHA256)
+	}
+}
+
+func TestNewRecordHashesNonEmptyPayload(t *testing.T) {
+	payload := []byte("hello")
+
+	record, err := NewRecord(&TaggedPacket{Packet: ipv4TCPPacket(t, payload)})
+	if err != nil {
+		t.Fatalf("NewRecord: %v", err)
+	}
+
+	sum := sha256.Sum256(payload)
+	want := hex.EncodeToString(sum[:])
+	if record.PayloadSHA256 != want {
+		t.Fatalf("got PayloadSHA256 %q, want %q", record.PayloadSHA256, want)
+	}
+}
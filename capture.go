@@ -0,0 +1,95 @@
+package badcapt
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// defaultSnapLen is used when SetSnapLen was not called.
+const defaultSnapLen = 1600
+
+// bpfSetter is implemented by pcap handles capable of filtering packets
+// with a BPF expression before they reach the packet loop.
+type bpfSetter interface {
+	SetBPFFilter(expr string) error
+}
+
+func (b *Badcapt) snapLen() int32 {
+	if b.snaplen <= 0 {
+		return defaultSnapLen
+	}
+
+	return b.snaplen
+}
+
+// applyBPFFilter sets the configured BPF filter on handle, if any.
+func (b *Badcapt) applyBPFFilter(handle bpfSetter) error {
+	if b.bpfFilter == "" {
+		return nil
+	}
+
+	return handle.SetBPFFilter(b.bpfFilter)
+}
+
+// SetBPFFilter restricts capture to packets matching a libpcap BPF
+// expression, e.g. "tcp and not port 22". It applies to Listen,
+// ListenContext and ListenPCAP; ListenReader reads via pcapgo, which has no
+// BPF support, and ignores it.
+func SetBPFFilter(expr string) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.bpfFilter = expr
+		return nil
+	}
+}
+
+// SetSnapLen sets the maximum number of bytes captured per packet. It
+// defaults to 1600.
+func SetSnapLen(n int32) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.snaplen = n
+		return nil
+	}
+}
+
+// SetPromiscuous toggles promiscuous mode for Listen and ListenContext. It
+// defaults to true.
+func SetPromiscuous(promisc bool) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.promisc = promisc
+		return nil
+	}
+}
+
+// ListenPCAP replays a saved capture file through the same marker and
+// exporter pipeline as Listen, for offline triage and regression testing of
+// markers. It respects SetBPFFilter.
+func (b *Badcapt) ListenPCAP(path string) error {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	if err := b.applyBPFFilter(handle); err != nil {
+		return err
+	}
+
+	return b.listen(context.Background(), gopacket.NewPacketSource(handle, handle.LinkType()))
+}
+
+// ListenReader replays a PCAP-formatted capture read from r through the
+// same marker and exporter pipeline as Listen. Unlike ListenPCAP it uses
+// pcapgo, a pure Go PCAP reader, so it does not require libpcap and does
+// not support SetBPFFilter.
+func (b *Badcapt) ListenReader(r io.Reader) error {
+	reader, err := pcapgo.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	return b.listen(context.Background(), gopacket.NewPacketSource(reader, reader.LinkType()))
+}
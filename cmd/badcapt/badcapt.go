@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ilyaglow/badcapt"
 )
@@ -20,7 +24,7 @@ func main() {
 
 	var (
 		err  error
-		conf *badcapt.Config
+		conf *badcapt.Badcapt
 	)
 	if *debug {
 		conf, err = badcapt.New()
@@ -34,5 +38,16 @@ func main() {
 		}
 	}
 
-	log.Fatal(conf.Listen(*listenIface))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := conf.ListenContext(ctx, *listenIface)
+
+	if err := conf.Close(); err != nil {
+		log.Println(err)
+	}
+
+	if listenErr != nil {
+		log.Fatal(listenErr)
+	}
 }
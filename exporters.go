@@ -0,0 +1,166 @@
+package badcapt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/stan.go"
+	"github.com/olivere/elastic"
+	"github.com/segmentio/kafka-go"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Exporter is a sink a Record can be fanned out to.
+type Exporter interface {
+	Export(ctx context.Context, record *Record) error
+	Close() error
+}
+
+// elasticExporter writes records to an Elasticsearch index.
+type elasticExporter struct {
+	client    *elastic.Client
+	indexName string
+	docType   string
+}
+
+func (e *elasticExporter) Export(ctx context.Context, record *Record) error {
+	_, err := e.client.Index().
+		Index(e.indexName).
+		Type(e.docType).
+		BodyJson(record).
+		Do(ctx)
+
+	return err
+}
+
+func (e *elasticExporter) Close() error {
+	return nil
+}
+
+// screenExporter prints records as JSON to stdout. It is the default sink
+// when no Elasticsearch client and no other exporters were configured.
+type screenExporter struct{}
+
+func (screenExporter) Export(ctx context.Context, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+
+	return nil
+}
+
+func (screenExporter) Close() error {
+	return nil
+}
+
+// NatsExporter publishes records as JSON to a NATS Streaming subject with
+// at-least-once delivery.
+type NatsExporter struct {
+	conn    stan.Conn
+	subject string
+}
+
+// NewNatsExporter connects to a NATS Streaming cluster and returns an
+// Exporter that publishes every record to subject.
+func NewNatsExporter(clusterID, clientID, natsURL, subject string) (*NatsExporter, error) {
+	conn, err := stan.Connect(clusterID, clientID, stan.NatsURL(natsURL))
+	if err != nil {
+		return nil, err
+	}
+
+	return &NatsExporter{
+		conn:    conn,
+		subject: subject,
+	}, nil
+}
+
+func (n *NatsExporter) Export(ctx context.Context, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	ack := make(chan error, 1)
+	if _, err := n.conn.PublishAsync(n.subject, data, func(_ string, err error) {
+		ack <- err
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *NatsExporter) Close() error {
+	return n.conn.Close()
+}
+
+// KafkaExporter publishes records as JSON to a Kafka topic.
+type KafkaExporter struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaExporter returns an Exporter that produces records to topic on the
+// given brokers.
+func NewKafkaExporter(brokers []string, topic string) *KafkaExporter {
+	return &KafkaExporter{
+		writer: kafka.NewWriter(kafka.WriterConfig{
+			Brokers: brokers,
+			Topic:   topic,
+		}),
+	}
+}
+
+func (k *KafkaExporter) Export(ctx context.Context, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return k.writer.WriteMessages(ctx, kafka.Message{Value: data})
+}
+
+func (k *KafkaExporter) Close() error {
+	return k.writer.Close()
+}
+
+// FileExporter appends records as newline-delimited JSON to a file, rotating
+// it once it grows past the configured size.
+type FileExporter struct {
+	logger *lumberjack.Logger
+}
+
+// NewFileExporter returns an Exporter that writes JSONL records to path,
+// rotating it once it reaches maxSizeMB megabytes.
+func NewFileExporter(path string, maxSizeMB int) *FileExporter {
+	return &FileExporter{
+		logger: &lumberjack.Logger{
+			Filename: path,
+			MaxSize:  maxSizeMB,
+			Compress: true,
+		},
+	}
+}
+
+func (f *FileExporter) Export(ctx context.Context, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+	_, err = f.logger.Write(data)
+
+	return err
+}
+
+func (f *FileExporter) Close() error {
+	return f.logger.Close()
+}
@@ -0,0 +1,167 @@
+package badcapt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// defaultFlowWindow bounds how long a flow may sit idle before it is
+// considered closed and handed to the SeriesMarkers.
+const defaultFlowWindow = 30 * time.Second
+
+// maxFlowPackets bounds how many raw packets of a single flow rawPackets
+// retains. Without it, a flow that stays continuously active — a long
+// download, a kept-alive session — never goes idle and never sends
+// FIN/RST, so it would never be flushed by window or ReassemblyComplete
+// and would grow without bound for the life of the connection. Past the
+// cap, the oldest packets are dropped first; the SeriesMarkers only need a
+// recent window of activity; oldest-first-out is the same policy the scan
+// detectors use in scanCache.track.
+const maxFlowPackets = 1024
+
+// flowTable drives a tcpassembly.Assembler to reconstruct TCP flows keyed by
+// 5-tuple, keeping the raw packets of each flow alongside the reassembly so
+// SeriesMarkers can inspect more than payload bytes (flags, window size,
+// and so on). Flows are handed back once tcpassembly sees them close
+// (FIN/RST) or once they go idle past window; the latter is driven off the
+// timestamp of the most recently observed packet, so it behaves the same
+// whether packets arrive live or are replayed from a PCAP file.
+type flowTable struct {
+	mu         sync.Mutex
+	rawPackets map[string][]gopacket.Packet
+	ready      [][]gopacket.Packet
+	window     time.Duration
+	lastFlush  time.Time
+
+	// assemblerMu serializes access to assembler, which is documented as not
+	// safe for concurrent use, across the worker pool's goroutines. It is
+	// kept separate from mu so that the ReassemblyComplete callback invoked
+	// synchronously from within AssembleWithTimestamp/FlushOlderThan can
+	// still take mu without deadlocking against the goroutine that is
+	// holding assemblerMu.
+	assemblerMu sync.Mutex
+	assembler   *tcpassembly.Assembler
+}
+
+func newFlowTable(window time.Duration) *flowTable {
+	if window <= 0 {
+		window = defaultFlowWindow
+	}
+
+	t := &flowTable{
+		rawPackets: make(map[string][]gopacket.Packet),
+		window:     window,
+	}
+	t.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(&flowStreamFactory{table: t}))
+
+	return t
+}
+
+func flowKey(netFlow, tcpFlow gopacket.Flow) string {
+	return netFlow.String() + tcpFlow.String()
+}
+
+// observe feeds p's TCP segment through the reassembler and returns the raw
+// packets of any flow that just closed or went idle, removing them from the
+// table.
+func (t *flowTable) observe(p gopacket.Packet, ip4 *layers.IPv4, tcp *layers.TCP) [][]gopacket.Packet {
+	seen := p.Metadata().CaptureInfo.Timestamp
+	netFlow := ip4.NetworkFlow()
+	key := flowKey(netFlow, tcp.TransportFlow())
+
+	t.mu.Lock()
+	pkts := append(t.rawPackets[key], p)
+	if extra := len(pkts) - maxFlowPackets; extra > 0 {
+		pkts = pkts[extra:]
+	}
+	t.rawPackets[key] = pkts
+	if t.lastFlush.IsZero() {
+		t.lastFlush = seen
+	}
+	t.mu.Unlock()
+
+	t.assemblerMu.Lock()
+	t.assembler.AssembleWithTimestamp(netFlow, tcp, seen)
+
+	if seen.Sub(t.lastFlush) > t.window {
+		t.assembler.FlushOlderThan(seen.Add(-t.window))
+
+		t.mu.Lock()
+		t.lastFlush = seen
+		t.mu.Unlock()
+	}
+	t.assemblerMu.Unlock()
+
+	t.mu.Lock()
+	ready := t.ready
+	t.ready = nil
+	t.mu.Unlock()
+
+	return ready
+}
+
+// flowStreamFactory hands tcpassembly a Stream per 5-tuple; it does nothing
+// with the reassembled bytes itself, it only watches for ReassemblyComplete
+// so the table can hand the flow's raw packets back to the caller.
+type flowStreamFactory struct {
+	table *flowTable
+}
+
+func (f *flowStreamFactory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	return &flowStream{table: f.table, key: flowKey(netFlow, tcpFlow)}
+}
+
+type flowStream struct {
+	table *flowTable
+	key   string
+}
+
+func (s *flowStream) Reassembled(_ []tcpassembly.Reassembly) {}
+
+func (s *flowStream) ReassemblyComplete() {
+	t := s.table
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pkts := t.rawPackets[s.key]
+	delete(t.rawPackets, s.key)
+	if len(pkts) > 0 {
+		t.ready = append(t.ready, pkts)
+	}
+}
+
+// synOnly reports whether every packet of a flow was a bare SYN, i.e. the
+// three-way handshake never completed.
+func synOnly(packets []gopacket.Packet) bool {
+	for _, p := range packets {
+		tcp := unpackTCP(p)
+		if tcp == nil || !tcp.SYN || tcp.ACK {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AddSeriesMarker registers an additional routine that inspects the packets
+// of a closed or timed out flow, alongside the built-in scan detectors.
+func AddSeriesMarker(m SeriesMarker) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.seriesMarkers = append(b.seriesMarkers, m)
+		return nil
+	}
+}
+
+// FlowWindow sets how long a flow may stay idle before it is considered
+// closed and flushed through the SeriesMarkers. It defaults to 30 seconds.
+func FlowWindow(d time.Duration) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		b.flowWindow = d
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package badcapt
+
+import "github.com/oschwald/geoip2-golang"
+
+// GeoIPEnricher fills SrcCountry, SrcASN and SrcOrg from a MaxMind GeoLite2
+// database.
+type GeoIPEnricher struct {
+	db *geoip2.Reader
+}
+
+// NewGeoIPEnricher opens the GeoLite2 database at path and returns an
+// Enricher that looks up the source IP of every Record in it.
+func NewGeoIPEnricher(path string) (*GeoIPEnricher, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GeoIPEnricher{db: db}, nil
+}
+
+// Enrich fills record's GeoIP fields from SrcIP. ASN data is missing from
+// some GeoLite2 editions (e.g. City-only databases); when that lookup comes
+// back empty, SrcCountry is still populated.
+func (g *GeoIPEnricher) Enrich(record *Record) error {
+	city, err := g.db.City(record.SrcIP)
+	if err != nil {
+		return err
+	}
+	record.SrcCountry = city.Country.IsoCode
+
+	asn, err := g.db.ASN(record.SrcIP)
+	if err != nil {
+		return nil
+	}
+	record.SrcASN = asn.AutonomousSystemNumber
+	record.SrcOrg = asn.AutonomousSystemOrganization
+
+	return nil
+}
+
+// Close releases the underlying GeoLite2 database.
+func (g *GeoIPEnricher) Close() error {
+	return g.db.Close()
+}
@@ -0,0 +1,296 @@
+package badcapt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cloudflare/ahocorasick"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule describes a single Suricata/Snort-like payload signature loaded from
+// a rule file. A payload match is expressed exactly one of three ways: Hex,
+// Regexp, or an Offset/Bytes pair.
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Transport string   `json:"transport,omitempty" yaml:"transport,omitempty"` // "tcp", "udp" or "" for any
+	DstPorts  []uint16 `json:"dst_ports,omitempty" yaml:"dst_ports,omitempty"`
+	Hex       string   `json:"hex,omitempty" yaml:"hex,omitempty"`
+	Regexp    string   `json:"regexp,omitempty" yaml:"regexp,omitempty"`
+	Offset    int      `json:"offset,omitempty" yaml:"offset,omitempty"`
+	Bytes     string   `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+}
+
+// ruleFile is the on-disk shape of a rule file.
+type ruleFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// literalRule is a Rule whose payload pattern is matched with Aho-Corasick.
+type literalRule struct {
+	rule    Rule
+	pattern []byte
+}
+
+// offsetRule is a Rule whose payload pattern must appear at a fixed offset,
+// which Aho-Corasick cannot express, so it is checked directly.
+type offsetRule struct {
+	rule    Rule
+	pattern []byte
+}
+
+// regexpRule is a Rule whose payload pattern is matched with regexp.
+type regexpRule struct {
+	rule Rule
+	re   *regexp.Regexp
+}
+
+// ruleSet is a compiled, ready-to-match snapshot of a rule file.
+type ruleSet struct {
+	literals *ahocorasick.Matcher
+	literal  []literalRule
+	offsets  []offsetRule
+	regexps  []regexpRule
+}
+
+// ruleMarker is a Marker backed by a hot-reloadable ruleSet.
+type ruleMarker struct {
+	path string
+
+	mu sync.RWMutex
+	rs *ruleSet
+}
+
+func parseRuleFile(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rf ruleFile
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &rf)
+	} else {
+		err = yaml.Unmarshal(data, &rf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rf.Rules, nil
+}
+
+func compileRules(rules []Rule) (*ruleSet, error) {
+	rs := &ruleSet{}
+
+	var dictionary [][]byte
+	for _, r := range rules {
+		switch {
+		case r.Hex != "":
+			pattern, err := hex.DecodeString(r.Hex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+
+			rs.literal = append(rs.literal, literalRule{rule: r, pattern: pattern})
+			dictionary = append(dictionary, pattern)
+		case r.Regexp != "":
+			re, err := regexp.Compile(r.Regexp)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+
+			rs.regexps = append(rs.regexps, regexpRule{rule: r, re: re})
+		case r.Bytes != "":
+			pattern, err := hex.DecodeString(r.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+
+			if r.Offset < 0 {
+				return nil, fmt.Errorf("rule %q: negative offset %d", r.Name, r.Offset)
+			}
+			if r.Offset > math.MaxInt32-len(pattern) {
+				return nil, fmt.Errorf("rule %q: offset %d overflows with pattern length %d", r.Name, r.Offset, len(pattern))
+			}
+
+			rs.offsets = append(rs.offsets, offsetRule{rule: r, pattern: pattern})
+		default:
+			return nil, fmt.Errorf("rule %q: no hex, regexp or offset/bytes pattern", r.Name)
+		}
+	}
+
+	if len(dictionary) > 0 {
+		rs.literals = ahocorasick.NewMatcher(dictionary)
+	}
+
+	return rs, nil
+}
+
+func transportMatches(r Rule, tr string) bool {
+	return r.Transport == "" || strings.EqualFold(r.Transport, tr)
+}
+
+func dstPortMatches(r Rule, port uint16) bool {
+	if len(r.DstPorts) == 0 {
+		return true
+	}
+
+	for _, p := range r.DstPorts {
+		if p == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match runs a packet through the current ruleSet and returns the names of
+// every rule that fired.
+func (rm *ruleMarker) match(p gopacket.Packet) []string {
+	rm.mu.RLock()
+	rs := rm.rs
+	rm.mu.RUnlock()
+
+	var (
+		transport string
+		dstPort   uint16
+	)
+	if tcp := unpackTCP(p); tcp != nil {
+		transport = "tcp"
+		dstPort = uint16(tcp.DstPort)
+	} else if udpLayer := p.Layer(layers.LayerTypeUDP); udpLayer != nil {
+		transport = "udp"
+		dstPort = uint16(udpLayer.(*layers.UDP).DstPort)
+	} else {
+		return nil
+	}
+
+	appLayer := p.ApplicationLayer()
+	if appLayer == nil {
+		return nil
+	}
+	payload := appLayer.Payload()
+
+	var tags []string
+
+	if rs.literals != nil {
+		for _, idx := range rs.literals.MatchThreadSafe(payload) {
+			lr := rs.literal[idx]
+			if transportMatches(lr.rule, transport) && dstPortMatches(lr.rule, dstPort) {
+				tags = append(tags, lr.rule.Name)
+			}
+		}
+	}
+
+	for _, or := range rs.offsets {
+		if !transportMatches(or.rule, transport) || !dstPortMatches(or.rule, dstPort) {
+			continue
+		}
+
+		end := or.rule.Offset + len(or.pattern)
+		if end > len(payload) {
+			continue
+		}
+
+		if string(payload[or.rule.Offset:end]) == string(or.pattern) {
+			tags = append(tags, or.rule.Name)
+		}
+	}
+
+	for _, rr := range rs.regexps {
+		if !transportMatches(rr.rule, transport) || !dstPortMatches(rr.rule, dstPort) {
+			continue
+		}
+
+		if rr.re.Match(payload) {
+			tags = append(tags, rr.rule.Name)
+		}
+	}
+
+	return tags
+}
+
+// reload recompiles the rule file and swaps it in atomically. On error the
+// previous ruleSet keeps matching.
+func (rm *ruleMarker) reload() error {
+	rules, err := parseRuleFile(rm.path)
+	if err != nil {
+		return err
+	}
+
+	rs, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+
+	rm.mu.Lock()
+	rm.rs = rs
+	rm.mu.Unlock()
+
+	return nil
+}
+
+// watchReload recompiles the rule file every time the process receives
+// SIGHUP, so operators can push new signatures without restarting badcapt.
+func (rm *ruleMarker) watchReload() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		if err := rm.reload(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// LoadRules compiles a YAML or JSON rule file (selected by its extension,
+// defaulting to YAML) into a single Marker. Literal and hex patterns are
+// matched with Aho-Corasick so the cost of the whole rule set stays roughly
+// constant as rules are added; regexp rules run their own match per packet.
+// The rule file is reloaded on SIGHUP.
+func LoadRules(path string) (Marker, error) {
+	rules, err := parseRuleFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	rm := &ruleMarker{path: path, rs: rs}
+	go rm.watchReload()
+
+	return rm.match, nil
+}
+
+// AddRuleMarker loads a YAML/JSON rule file with LoadRules and registers it
+// as a packet marker, so detections can be extended without recompiling
+// badcapt.
+func AddRuleMarker(path string) func(*Badcapt) error {
+	return func(b *Badcapt) error {
+		m, err := LoadRules(path)
+		if err != nil {
+			return err
+		}
+
+		b.markers = append(b.markers, m)
+
+		return nil
+	}
+}
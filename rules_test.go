@@ -0,0 +1,99 @@
+package badcapt
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func tcpPacket(t *testing.T, dstPort uint16, payload []byte) gopacket.Packet {
+	t.Helper()
+
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(12345),
+		DstPort: layers.TCPPort(dstPort),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("serialize packet: %v", err)
+	}
+
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeTCP, gopacket.Default)
+}
+
+func TestCompileRulesHexMatch(t *testing.T) {
+	rs, err := compileRules([]Rule{{Name: "telnet-login", Hex: "6c6f67696e3a"}})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+
+	rm := &ruleMarker{rs: rs}
+	tags := rm.match(tcpPacket(t, 23, []byte("login: root\n")))
+	if len(tags) != 1 || tags[0] != "telnet-login" {
+		t.Fatalf("got tags %v, want [telnet-login]", tags)
+	}
+
+	if tags := rm.match(tcpPacket(t, 23, []byte("no match here"))); len(tags) != 0 {
+		t.Fatalf("got tags %v, want none", tags)
+	}
+}
+
+func TestCompileRulesRegexpMatch(t *testing.T) {
+	rs, err := compileRules([]Rule{{Name: "http-get", Regexp: `^GET /\w+ HTTP/1\.[01]`}})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+
+	rm := &ruleMarker{rs: rs}
+	tags := rm.match(tcpPacket(t, 80, []byte("GET /index HTTP/1.1\r\n")))
+	if len(tags) != 1 || tags[0] != "http-get" {
+		t.Fatalf("got tags %v, want [http-get]", tags)
+	}
+}
+
+func TestCompileRulesOffsetMatch(t *testing.T) {
+	rs, err := compileRules([]Rule{{Name: "magic-at-4", Offset: 4, Bytes: "deadbeef"}})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+
+	rm := &ruleMarker{rs: rs}
+	payload := append([]byte{0, 0, 0, 0}, []byte{0xde, 0xad, 0xbe, 0xef}...)
+	tags := rm.match(tcpPacket(t, 4444, payload))
+	if len(tags) != 1 || tags[0] != "magic-at-4" {
+		t.Fatalf("got tags %v, want [magic-at-4]", tags)
+	}
+
+	if tags := rm.match(tcpPacket(t, 4444, []byte{0xde, 0xad, 0xbe, 0xef})); len(tags) != 0 {
+		t.Fatalf("got tags %v, want none when payload is too short to hold the pattern at the offset", tags)
+	}
+}
+
+func TestCompileRulesFiltersByDstPort(t *testing.T) {
+	rs, err := compileRules([]Rule{{Name: "http-get", Regexp: "GET", DstPorts: []uint16{80}}})
+	if err != nil {
+		t.Fatalf("compileRules: %v", err)
+	}
+
+	rm := &ruleMarker{rs: rs}
+	if tags := rm.match(tcpPacket(t, 8080, []byte("GET / HTTP/1.1"))); len(tags) != 0 {
+		t.Fatalf("got tags %v, want none for a non-matching dst port", tags)
+	}
+}
+
+func TestCompileRulesRejectsNegativeOffset(t *testing.T) {
+	_, err := compileRules([]Rule{{Name: "bad-offset", Offset: -1, Bytes: "deadbeef"}})
+	if err == nil {
+		t.Fatal("expected an error for a negative offset, got nil")
+	}
+}
+
+func TestCompileRulesRejectsNoPattern(t *testing.T) {
+	_, err := compileRules([]Rule{{Name: "empty"}})
+	if err == nil {
+		t.Fatal("expected an error for a rule with no pattern, got nil")
+	}
+}
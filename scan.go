@@ -0,0 +1,126 @@
+package badcapt
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/google/gopacket/layers"
+)
+
+// Thresholds used by the built-in scan detectors. They are conservative
+// defaults for a single vantage point. The counters behind them are not
+// expired by time and are not bounded by FlowWindow: a source's counters
+// are only forgotten once fastcache evicts them to make room.
+const (
+	horizontalScanPorts = 15
+	verticalScanHosts   = 15
+	synFloodPorts       = 10
+	slowScanMinPorts    = 3
+	slowScanMinSpan     = 5 * time.Minute
+)
+
+// scanCache keeps bounded, per-source sets of recently seen ports or hosts
+// in the fastcache instance sized by SetCacheSize, so the scan detectors
+// scale to high packet rates without unbounded memory growth.
+type scanCache struct {
+	cache *fastcache.Cache
+}
+
+// track records item under key, capping the remembered set at limit entries
+// (oldest first out) and returns how many distinct items are now on file
+// along with the time the key was first seen.
+func (s *scanCache) track(key string, item uint32, limit int, seen time.Time) (count int, first time.Time) {
+	buf := s.cache.Get(nil, []byte(key))
+
+	if len(buf) < 8 {
+		buf = make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, uint64(seen.UnixNano()))
+	}
+	first = time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8])))
+
+	for i := 8; i+4 <= len(buf); i += 4 {
+		if binary.BigEndian.Uint32(buf[i:i+4]) == item {
+			return (len(buf) - 8) / 4, first
+		}
+	}
+
+	entry := make([]byte, 4)
+	binary.BigEndian.PutUint32(entry, item)
+	buf = append(buf, entry...)
+
+	if extra := (len(buf)-8)/4 - limit; extra > 0 {
+		buf = append(buf[:8], buf[8+extra*4:]...)
+	}
+
+	s.cache.Set([]byte(key), buf)
+
+	return (len(buf) - 8) / 4, first
+}
+
+func ipv4ToUint32(ip4 *layers.IPv4) uint32 {
+	return binary.BigEndian.Uint32(ip4.DstIP.To4())
+}
+
+// horizontalScan flags a source hitting horizontalScanPorts or more distinct
+// destination ports.
+func (b *Badcapt) horizontalScan(ip4 *layers.IPv4, dstPort uint16, seen time.Time) []string {
+	n, _ := b.scan.track("hscan:"+ip4.SrcIP.String(), uint32(dstPort), horizontalScanPorts, seen)
+	if n >= horizontalScanPorts {
+		return []string{"horizontal-port-scan"}
+	}
+
+	return nil
+}
+
+// verticalScan flags a source hitting the same destination port on
+// verticalScanHosts or more distinct hosts.
+func (b *Badcapt) verticalScan(ip4 *layers.IPv4, dstPort uint16, seen time.Time) []string {
+	key := "vscan:" + ip4.SrcIP.String() + ":" + strconv.Itoa(int(dstPort))
+	n, _ := b.scan.track(key, ipv4ToUint32(ip4), verticalScanHosts, seen)
+	if n >= verticalScanHosts {
+		return []string{"vertical-port-scan"}
+	}
+
+	return nil
+}
+
+// slowScan flags a source that only trickles a handful of distinct ports,
+// but keeps doing so across slowScanMinSpan or longer, the hallmark of a
+// scan paced to dodge rate-based detectors.
+func (b *Badcapt) slowScan(ip4 *layers.IPv4, dstPort uint16, seen time.Time) []string {
+	n, first := b.scan.track("sscan:"+ip4.SrcIP.String(), uint32(dstPort), horizontalScanPorts, seen)
+	if n >= slowScanMinPorts && n < horizontalScanPorts && seen.Sub(first) >= slowScanMinSpan {
+		return []string{"slow-scan"}
+	}
+
+	return nil
+}
+
+// synOnlyFlood flags a source that keeps opening TCP flows with a SYN and
+// nothing else (no completed handshake) against a growing number of
+// distinct destination ports.
+func (b *Badcapt) synOnlyFlood(ip4 *layers.IPv4, dstPort uint16, seen time.Time) []string {
+	n, _ := b.scan.track("synflood:"+ip4.SrcIP.String(), uint32(dstPort), synFloodPorts, seen)
+	if n >= synFloodPorts {
+		return []string{"syn-flood"}
+	}
+
+	return nil
+}
+
+// scanTags runs every built-in scan detector against a single packet's
+// 5-tuple and returns the union of the tags they raise. The syn-flood
+// detector is not among them: whether a SYN-only flow turns into a flood
+// can only be told once the flow closes, so it runs as synOnlyFloodMarker
+// over the flow's full packet set instead.
+func (b *Badcapt) scanTags(ip4 *layers.IPv4, dstPort uint16, seen time.Time) []string {
+	var tags []string
+
+	tags = append(tags, b.horizontalScan(ip4, dstPort, seen)...)
+	tags = append(tags, b.verticalScan(ip4, dstPort, seen)...)
+	tags = append(tags, b.slowScan(ip4, dstPort, seen)...)
+
+	return tags
+}
@@ -0,0 +1,75 @@
+package badcapt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+func newScanCache() *scanCache {
+	return &scanCache{cache: fastcache.New(1024 * 1024)}
+}
+
+func TestScanCacheTrackCountsDistinctItems(t *testing.T) {
+	s := newScanCache()
+	now := time.Now()
+
+	for i, item := range []uint32{1, 2, 3} {
+		n, _ := s.track("key", item, 10, now)
+		if n != i+1 {
+			t.Fatalf("item %d: got count %d, want %d", item, n, i+1)
+		}
+	}
+}
+
+func TestScanCacheTrackIgnoresRepeats(t *testing.T) {
+	s := newScanCache()
+	now := time.Now()
+
+	s.track("key", 1, 10, now)
+	s.track("key", 2, 10, now)
+	n, _ := s.track("key", 1, 10, now)
+
+	if n != 2 {
+		t.Fatalf("got count %d, want 2 after re-seeing item 1", n)
+	}
+}
+
+func TestScanCacheTrackEvictsOldestPastLimit(t *testing.T) {
+	s := newScanCache()
+	now := time.Now()
+
+	const limit = 3
+	var n int
+	for _, item := range []uint32{1, 2, 3, 4, 5} {
+		n, _ = s.track("key", item, limit, now)
+		if n > limit {
+			t.Fatalf("item %d: count %d exceeds limit %d", item, n, limit)
+		}
+	}
+
+	if n != limit {
+		t.Fatalf("got final count %d, want %d", n, limit)
+	}
+
+	// item 1 was evicted to make room for 4 and 5, so tracking it again is
+	// treated as new rather than a no-op, and the count stays capped.
+	n, _ = s.track("key", 1, limit, now)
+	if n != limit {
+		t.Fatalf("got count %d after re-adding evicted item, want %d", n, limit)
+	}
+}
+
+func TestScanCacheTrackKeepsFirstSeenTimestamp(t *testing.T) {
+	s := newScanCache()
+	first := time.Now().Add(-time.Minute)
+	later := first.Add(30 * time.Second)
+
+	s.track("key", 1, 10, first)
+	_, got := s.track("key", 2, 10, later)
+
+	if !got.Equal(first) {
+		t.Fatalf("got first-seen %v, want %v", got, first)
+	}
+}